@@ -0,0 +1,126 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lockfileCacheCapacity is the maximum number of entries kept in lockfileCache
+// before the least recently used one is evicted.
+const lockfileCacheCapacity = 1000
+
+// lockfileCacheEntry is the cached, already-serialized response for a repository.
+type lockfileCacheEntry struct {
+	repositoryId string
+	body         []byte
+	etag         string
+}
+
+// lockfileCacheMetrics tracks hit/miss/eviction counts for the /metrics endpoint.
+type lockfileCacheMetrics struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// lruCache is a fixed-capacity, in-memory LRU cache of serialized lockfile responses.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	metrics  lockfileCacheMetrics
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached entry for repositoryId, if present, bumping it to
+// most-recently-used.
+func (cache *lruCache) get(repositoryId string) (lockfileCacheEntry, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[repositoryId]
+	if !ok {
+		cache.metrics.misses++
+		return lockfileCacheEntry{}, false
+	}
+
+	cache.order.MoveToFront(element)
+	cache.metrics.hits++
+	return element.Value.(lockfileCacheEntry), true
+}
+
+// set stores or replaces the entry for repositoryId, evicting the least
+// recently used entry if the cache is at capacity.
+func (cache *lruCache) set(entry lockfileCacheEntry) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[entry.repositoryId]; ok {
+		element.Value = entry
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[entry.repositoryId] = cache.order.PushFront(entry)
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		if oldest != nil {
+			cache.order.Remove(oldest)
+			delete(cache.entries, oldest.Value.(lockfileCacheEntry).repositoryId)
+			cache.metrics.evictions++
+		}
+	}
+}
+
+// invalidate removes repositoryId's entry, if any, from the cache.
+func (cache *lruCache) invalidate(repositoryId string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[repositoryId]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, repositoryId)
+	}
+}
+
+// snapshotMetrics returns a point-in-time copy of the cache's metrics.
+func (cache *lruCache) snapshotMetrics() lockfileCacheMetrics {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.metrics
+}
+
+var lockfileCache = newLRUCache(lockfileCacheCapacity)
+
+// lockfileETag computes a strong ETag as a SHA-256 hex digest of the
+// serialized response body.
+func lockfileETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// MetricsHandler handles the GET request exposing cache hit/miss/eviction counts.
+func MetricsHandler(c *gin.Context) {
+	metrics := lockfileCache.snapshotMetrics()
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"cacheHits":      metrics.hits,
+			"cacheMisses":    metrics.misses,
+			"cacheEvictions": metrics.evictions,
+		},
+	})
+}
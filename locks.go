@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// defaultLockTTL is how long a lock is held before it is considered expired
+// if the holder never refreshes or releases it.
+const defaultLockTTL = 2 * time.Minute
+
+// RepositoryLock is the database model for a repository's application-level lock.
+type RepositoryLock struct {
+	RepositoryID string    `db:"repository_id" json:"repositoryId"`
+	LockID       uuid.UUID `db:"lock_id" json:"lockId"`
+	Holder       string    `db:"holder" json:"holder"`
+	ExpiresAt    time.Time `db:"expires_at" json:"expiresAt"`
+}
+
+// RepositoryLocksTableSchema is the schema for the repository_locks table.
+const RepositoryLocksTableSchema = `
+CREATE TABLE IF NOT EXISTS repository_locks (
+    repository_id VARCHAR(255) PRIMARY KEY,
+    lock_id UUID NOT NULL,
+    holder VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+`
+
+type SetLockRequest struct {
+	Holder string `json:"holder" binding:"required"`
+}
+
+// SetLockHandler handles the POST request to acquire a lock on a repository.
+func SetLockHandler(c *gin.Context, db *sqlx.DB) {
+	var request SetLockRequest
+
+	repositoryId := c.Param("repositoryId")
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lockId := uuid.New()
+	expiresAt := time.Now().Add(defaultLockTTL)
+
+	_, err := db.Exec(
+		`INSERT INTO repository_locks (repository_id, lock_id, holder, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (repository_id) DO UPDATE
+		SET lock_id = $2, holder = $3, expires_at = $4
+		WHERE repository_locks.expires_at < CURRENT_TIMESTAMP`,
+		repositoryId, lockId, request.Holder, expiresAt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var lock RepositoryLock
+	if err := db.Get(&lock, "SELECT * FROM repository_locks WHERE repository_id = $1", repositoryId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if lock.LockID != lockId {
+		c.JSON(http.StatusConflict, gin.H{"error": "repository is already locked", "data": lock})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": lock})
+}
+
+// RefreshLockHandler handles the POST request to extend the TTL of a held lock.
+func RefreshLockHandler(c *gin.Context, db *sqlx.DB) {
+	repositoryId := c.Param("repositoryId")
+	lockToken := c.GetHeader("X-Lock-Token")
+	if lockToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Lock-Token header is required"})
+		return
+	}
+
+	result, err := db.Exec(
+		`UPDATE repository_locks SET expires_at = $1
+		WHERE repository_id = $2 AND lock_id = $3 AND expires_at >= CURRENT_TIMESTAMP`,
+		time.Now().Add(defaultLockTTL), repositoryId, lockToken,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock token is invalid or expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "lock refreshed successfully"})
+}
+
+// UnlockHandler handles the DELETE request to release a held lock.
+func UnlockHandler(c *gin.Context, db *sqlx.DB) {
+	repositoryId := c.Param("repositoryId")
+	lockToken := c.GetHeader("X-Lock-Token")
+	if lockToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Lock-Token header is required"})
+		return
+	}
+
+	result, err := db.Exec(
+		"DELETE FROM repository_locks WHERE repository_id = $1 AND lock_id = $2",
+		repositoryId, lockToken,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "lock token is invalid or expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "lock released successfully"})
+}
+
+// LockMiddleware rejects PUTs to a repository that is currently locked by
+// someone else, unless the request carries the matching X-Lock-Token.
+func LockMiddleware(db *sqlx.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		repositoryId := c.Param("repositoryId")
+
+		var lock RepositoryLock
+		err := db.Get(&lock, "SELECT * FROM repository_locks WHERE repository_id = $1 AND expires_at >= CURRENT_TIMESTAMP", repositoryId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.Next()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.GetHeader("X-Lock-Token") != lock.LockID.String() {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "repository is locked", "data": lock})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// sweepExpiredLocks runs forever, periodically deleting locks that have expired.
+func sweepExpiredLocks(db *sqlx.DB) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := db.Exec("DELETE FROM repository_locks WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+			log.Println("failed to sweep expired locks", err)
+		}
+	}
+}
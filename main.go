@@ -30,6 +30,7 @@ type Lockfile struct {
 	RepositoryName string               `db:"repository_name" json:"repositoryName"`
 	RepositoryID   string               `db:"repository_id" json:"repositoryId"`
 	Content        LockfileContentArray `db:"content" json:"content"`
+	ContentHash    string               `db:"content_hash" json:"contentHash"`
 	CreatedAt      time.Time            `db:"created_at" json:"createdAt"`
 	UpdatedAt      time.Time            `db:"updated_at" json:"updatedAt"`
 }
@@ -77,6 +78,7 @@ CREATE TABLE IF NOT EXISTS lockfiles (
     repository_id VARCHAR(255) NOT NULL UNIQUE,
     repository_name VARCHAR(255) NOT NULL,
     content JSON NOT NULL,
+    content_hash VARCHAR(64) NOT NULL DEFAULT '',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -96,6 +98,16 @@ func main() {
 		panic("DATABASE_URL is not set")
 	}
 
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		panic("JWT_SECRET is not set")
+	}
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		panic("ADMIN_TOKEN is not set")
+	}
+
 	db := sqlx.MustConnect("postgres", databaseUrl)
 
 	err := initTables(db)
@@ -103,6 +115,22 @@ func main() {
 		panic(err)
 	}
 
+	if _, err := db.Exec(RepositoryLocksTableSchema); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.Exec(AccessTokensTableSchema); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.Exec(WebhooksTableSchema); err != nil {
+		panic(err)
+	}
+
+	if _, err := db.Exec(LockfileVersionsTableSchema); err != nil {
+		panic(err)
+	}
+
 	githubActionsIPs, err = fetchGithubActionsIPs()
 	if err != nil {
 		panic(err)
@@ -113,9 +141,54 @@ func main() {
 	router.GET("/lockfiles/:repositoryId", func(ctx *gin.Context) {
 		GetLockfileHandler(ctx, db)
 	})
-	router.PUT("/lockfiles/:repositoryId", IPFilterMiddleware(githubActionsIPs.Actions), func(ctx *gin.Context) {
-		PutLockfileHandler(ctx, db)
+	repositoryWriteAuth := RepositoryWriteAuthMiddleware(githubActionsIPs.Actions, db, []byte(jwtSecret))
+
+	router.PUT(
+		"/lockfiles/:repositoryId",
+		repositoryWriteAuth,
+		LockMiddleware(db),
+		func(ctx *gin.Context) {
+			PutLockfileHandler(ctx, db)
+		},
+	)
+	router.POST("/lockfiles/:repositoryId/lock", repositoryWriteAuth, func(ctx *gin.Context) {
+		SetLockHandler(ctx, db)
+	})
+	router.POST("/lockfiles/:repositoryId/lock/refresh", repositoryWriteAuth, func(ctx *gin.Context) {
+		RefreshLockHandler(ctx, db)
+	})
+	router.DELETE("/lockfiles/:repositoryId/lock", repositoryWriteAuth, func(ctx *gin.Context) {
+		UnlockHandler(ctx, db)
+	})
+	router.POST("/tokens", AdminMiddleware(adminToken), func(ctx *gin.Context) {
+		MintTokenHandler(ctx, db, []byte(jwtSecret))
 	})
+	router.DELETE("/tokens/:tokenId", AdminMiddleware(adminToken), func(ctx *gin.Context) {
+		RevokeTokenHandler(ctx, db)
+	})
+	router.POST("/lockfiles/:repositoryId/webhooks", repositoryWriteAuth, func(ctx *gin.Context) {
+		CreateWebhookSubscriptionHandler(ctx, db)
+	})
+	router.GET("/lockfiles/:repositoryId/webhooks", repositoryWriteAuth, func(ctx *gin.Context) {
+		ListWebhookSubscriptionsHandler(ctx, db)
+	})
+	router.DELETE("/lockfiles/:repositoryId/webhooks/:webhookId", repositoryWriteAuth, func(ctx *gin.Context) {
+		DeleteWebhookSubscriptionHandler(ctx, db)
+	})
+	router.GET("/lockfiles/:repositoryId/webhooks/:webhookId/deliveries", repositoryWriteAuth, func(ctx *gin.Context) {
+		GetWebhookDeliveriesHandler(ctx, db)
+	})
+	router.GET("/metrics", MetricsHandler)
+	router.GET("/lockfiles/:repositoryId/versions", func(ctx *gin.Context) {
+		ListLockfileVersionsHandler(ctx, db)
+	})
+	router.GET("/lockfiles/:repositoryId/versions/:versionId", func(ctx *gin.Context) {
+		GetLockfileVersionHandler(ctx, db)
+	})
+
+	go sweepExpiredLocks(db)
+	startWebhookDispatchWorkers(db, webhookDispatchWorkerCount)
+	go recoverStuckDeliveries(db)
 
 	// Fetch the Github Actions IPs every 30 minutes
 	go func() {
@@ -140,20 +213,61 @@ func PingHandler(c *gin.Context) {
 
 // GetLockfileHandler handles the GET request for the lockfile.
 func GetLockfileHandler(c *gin.Context, db *sqlx.DB) {
-	var lockfile Lockfile
-
 	repositoryId := c.Param("repositoryId")
-	if err := db.Get(&lockfile, "SELECT * FROM lockfiles WHERE repository_id = $1", repositoryId); err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusOK, gin.H{"data": nil})
+
+	entry, ok := lockfileCache.get(repositoryId)
+	if !ok {
+		var lockfile Lockfile
+		var data interface{}
+
+		if err := db.Get(&lockfile, "SELECT * FROM lockfiles WHERE repository_id = $1", repositoryId); err != nil {
+			if err != sql.ErrNoRows {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		} else {
+			data = lockfile
+		}
+
+		body, err := json.Marshal(data)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		entry = lockfileCacheEntry{repositoryId: repositoryId, body: body, etag: lockfileETag(body)}
+		lockfileCache.set(entry)
+	}
+
+	serveCachedLockfile(c, entry, currentLock(db, repositoryId))
+}
+
+// serveCachedLockfile writes a cached entry's data, honoring If-None-Match
+// against the data's ETag, alongside a freshly queried lock. Lock state is
+// deliberately left out of the cached body and the ETag: SetLock, RefreshLock,
+// Unlock and natural expiry all change it without touching the lockfile's
+// content, so caching it would let GET serve a released or expired lock (or
+// miss a newly acquired one) until the next PUT invalidated the entry.
+func serveCachedLockfile(c *gin.Context, entry lockfileCacheEntry, lock *RepositoryLock) {
+	c.Header("ETag", entry.etag)
+	c.Header("Cache-Control", "public, max-age=60")
+
+	if c.GetHeader("If-None-Match") == entry.etag {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": lockfile})
+	c.JSON(http.StatusOK, gin.H{"data": json.RawMessage(entry.body), "lock": lock})
+}
+
+// currentLock returns the active lock for a repository, or nil if it is unlocked.
+func currentLock(db *sqlx.DB, repositoryId string) *RepositoryLock {
+	var lock RepositoryLock
+	if err := db.Get(&lock, "SELECT * FROM repository_locks WHERE repository_id = $1 AND expires_at >= CURRENT_TIMESTAMP", repositoryId); err != nil {
+		return nil
+	}
+
+	return &lock
 }
 
 // PutLockfileHandler handles the PUT request for the lockfile.
@@ -166,15 +280,54 @@ func PutLockfileHandler(c *gin.Context, db *sqlx.DB) {
 		return
 	}
 
-	_, err := db.NamedExec(
-		`INSERT INTO lockfiles (repository_id, repository_name, content, updated_at)
-		VALUES (:repository_id, :repository_name, :content, CURRENT_TIMESTAMP)
+	incomingHash, err := canonicalContentHash(request.Posts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var previous Lockfile
+	exists := true
+	if err := tx.Get(&previous, "SELECT * FROM lockfiles WHERE repository_id = $1", repositoryId); err != nil {
+		if err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		exists = false
+	}
+
+	if exists {
+		if ifMatch := c.GetHeader("If-Match"); ifMatch != "" && ifMatch != previous.ContentHash {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "content hash does not match If-Match", "data": previous})
+			return
+		}
+
+		if previous.ContentHash == incomingHash {
+			// net/http strips the body from a 304 response, so there is no
+			// point JSON-encoding one here.
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	_, err = tx.NamedExec(
+		`INSERT INTO lockfiles (repository_id, repository_name, content, content_hash, updated_at)
+		VALUES (:repository_id, :repository_name, :content, :content_hash, CURRENT_TIMESTAMP)
 		ON CONFLICT (repository_id) DO UPDATE
-		SET content = :content, repository_name = :repository_name, updated_at = CURRENT_TIMESTAMP`,
+		SET content = :content, content_hash = :content_hash, repository_name = :repository_name, updated_at = CURRENT_TIMESTAMP`,
 		map[string]interface{}{
 			"repository_name": request.RepositoryName,
 			"repository_id":   repositoryId,
 			"content":         request.Posts,
+			"content_hash":    incomingHash,
 			"updated_at":      time.Now(),
 		},
 	)
@@ -183,6 +336,26 @@ func PutLockfileHandler(c *gin.Context, db *sqlx.DB) {
 		return
 	}
 
+	if err := recordLockfileVersion(tx, repositoryId, request.Posts, incomingHash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff := diffLockfileContent(previous.Content, request.Posts)
+	deliveryIds, err := recordLockfileUpdateDeliveries(tx, repositoryId, diff)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	enqueueDeliveries(deliveryIds)
+	lockfileCache.invalidate(repositoryId)
+
 	c.JSON(http.StatusOK, gin.H{"data": "lockfile updated successfully"})
 }
 
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// scopeLockfileWrite is the only scope currently issued; it authorizes PUTs
+// to a single repository's lockfile.
+const scopeLockfileWrite = "lockfile:write"
+
+// AccessToken is the database model for an issued JWT, tracked so it can be revoked.
+type AccessToken struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	RepositoryID string     `db:"repository_id" json:"repositoryId"`
+	Scopes       string     `db:"scopes" json:"scopes"`
+	CreatedAt    time.Time  `db:"created_at" json:"createdAt"`
+	ExpiresAt    time.Time  `db:"expires_at" json:"expiresAt"`
+	RevokedAt    *time.Time `db:"revoked_at" json:"revokedAt"`
+}
+
+// AccessTokensTableSchema is the schema for the access_tokens table.
+const AccessTokensTableSchema = `
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id UUID NOT NULL PRIMARY KEY,
+    repository_id VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+`
+
+// jwtClaims is the payload signed into every access token.
+type jwtClaims struct {
+	Sub    string   `json:"sub"`
+	Iat    int64    `json:"iat"`
+	Exp    int64    `json:"exp"`
+	Scopes []string `json:"scopes"`
+	Jti    string   `json:"jti"`
+}
+
+// MintTokenRequest is the payload for POST /tokens.
+type MintTokenRequest struct {
+	RepositoryID string `json:"repositoryId" binding:"required"`
+	TTLSeconds   int64  `json:"ttlSeconds"`
+}
+
+// defaultTokenTTL is used when MintTokenRequest.TTLSeconds is left unset.
+const defaultTokenTTL = 24 * time.Hour
+
+// AdminMiddleware guards admin-only endpoints with a static bearer token
+// configured out of band via the ADMIN_TOKEN environment variable.
+func AdminMiddleware(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// MintTokenHandler handles the POST request to issue a JWT scoped to a single repository.
+func MintTokenHandler(c *gin.Context, db *sqlx.DB, signingSecret []byte) {
+	var request MintTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ttl := defaultTokenTTL
+	if request.TTLSeconds > 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+	}
+
+	now := time.Now()
+	id := uuid.New()
+	claims := jwtClaims{
+		Sub:    request.RepositoryID,
+		Iat:    now.Unix(),
+		Exp:    now.Add(ttl).Unix(),
+		Scopes: []string{scopeLockfileWrite},
+		Jti:    id.String(),
+	}
+
+	token, err := signJWT(claims, signingSecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO access_tokens (id, repository_id, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		id, request.RepositoryID, scopeLockfileWrite, now, time.Unix(claims.Exp, 0),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"token": token, "expiresAt": time.Unix(claims.Exp, 0)}})
+}
+
+// RevokeTokenHandler handles the DELETE request to revoke a previously issued token.
+func RevokeTokenHandler(c *gin.Context, db *sqlx.DB) {
+	tokenId := c.Param("tokenId")
+
+	result, err := db.Exec("UPDATE access_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL", tokenId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found or already revoked"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "token revoked successfully"})
+}
+
+// authorizeJWT verifies the Authorization: Bearer <jwt> header against
+// signingSecret and confirms scope, subject and revocation status for the
+// :repositoryId in the URL. It is the JWT half of RepositoryWriteAuthMiddleware
+// — there is no route that accepts a JWT without also accepting the GitHub
+// Actions IP allowlist, so it is not exposed as a standalone middleware.
+func authorizeJWT(c *gin.Context, db *sqlx.DB, signingSecret []byte) (jwtClaims, bool) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		return jwtClaims{}, false
+	}
+
+	claims, err := verifyJWT(token, signingSecret)
+	if err != nil {
+		return jwtClaims{}, false
+	}
+
+	if claims.Sub != c.Param("repositoryId") || !hasScope(claims.Scopes, scopeLockfileWrite) {
+		return jwtClaims{}, false
+	}
+
+	var revoked bool
+	if err := db.Get(&revoked, "SELECT revoked_at IS NOT NULL FROM access_tokens WHERE id = $1", claims.Jti); err != nil || revoked {
+		return jwtClaims{}, false
+	}
+
+	return claims, true
+}
+
+// RepositoryWriteAuthMiddleware authorizes a request that either comes from a
+// GitHub Actions IP or carries a JWT scoped to the :repositoryId in the URL,
+// and aborts with 403 otherwise.
+func RepositoryWriteAuthMiddleware(allowedIPs []string, db *sqlx.DB, signingSecret []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		githubActionsIPsMutex.Lock()
+		allowedByIP := isAllowedIP(c.ClientIP(), allowedIPs)
+		githubActionsIPsMutex.Unlock()
+
+		if allowedByIP {
+			c.Next()
+			return
+		}
+
+		if _, ok := authorizeJWT(c, db, signingSecret); ok {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signJWT produces a compact HMAC-SHA256 signed token: base64url(header).base64url(claims).base64url(sig).
+func signJWT(claims jwtClaims, secret []byte) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claimsJson)
+
+	signingInput := header + "." + payload
+	signature := hmacSign(signingInput, secret)
+
+	return signingInput + "." + signature, nil
+}
+
+// verifyJWT checks the signature and expiry of a compact JWT and returns its claims.
+func verifyJWT(token string, secret []byte) (jwtClaims, error) {
+	var claims jwtClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	if hmacSign(signingInput, secret) != parts[2] {
+		return claims, errors.New("invalid signature")
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("invalid claims encoding: %w", err)
+	}
+
+	if err := json.Unmarshal(claimsJson, &claims); err != nil {
+		return claims, fmt.Errorf("invalid claims: %w", err)
+	}
+
+	if time.Now().Unix() > claims.Exp {
+		return claims, errors.New("token expired")
+	}
+
+	return claims, nil
+}
+
+func hmacSign(input string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// defaultVersionRetention is how many historical versions are kept per
+// repository when VERSION_RETENTION_COUNT is not set.
+const defaultVersionRetention = 20
+
+// LockfileVersion is the database model for a single historical snapshot of a lockfile.
+type LockfileVersion struct {
+	ID           uuid.UUID            `db:"id" json:"id"`
+	RepositoryID string               `db:"repository_id" json:"repositoryId"`
+	Content      LockfileContentArray `db:"content" json:"content"`
+	ContentHash  string               `db:"content_hash" json:"contentHash"`
+	CreatedAt    time.Time            `db:"created_at" json:"createdAt"`
+}
+
+// LockfileVersionsTableSchema is the schema for the lockfile_versions table.
+const LockfileVersionsTableSchema = `
+CREATE TABLE IF NOT EXISTS lockfile_versions (
+    id UUID NOT NULL PRIMARY KEY,
+    repository_id VARCHAR(255) NOT NULL,
+    content JSON NOT NULL,
+    content_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// canonicalContentHash computes a SHA-256 hex digest of posts, canonicalized
+// by sorting on ID so the hash is stable across reordering of the same set of posts.
+func canonicalContentHash(posts LockfileContentArray) (string, error) {
+	sorted := make(LockfileContentArray, len(posts))
+	copy(sorted, posts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	canonicalJson, err := json.Marshal(sorted)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonicalJson)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordLockfileVersion inserts a new historical snapshot and prunes old ones
+// beyond VERSION_RETENTION_COUNT (default defaultVersionRetention).
+func recordLockfileVersion(tx *sqlx.Tx, repositoryId string, content LockfileContentArray, contentHash string) error {
+	_, err := tx.Exec(
+		`INSERT INTO lockfile_versions (id, repository_id, content, content_hash)
+		VALUES ($1, $2, $3, $4)`,
+		uuid.New(), repositoryId, content, contentHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	retention := defaultVersionRetention
+	if configured := os.Getenv("VERSION_RETENTION_COUNT"); configured != "" {
+		if parsed, err := strconv.Atoi(configured); err == nil && parsed > 0 {
+			retention = parsed
+		}
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM lockfile_versions
+		WHERE repository_id = $1 AND id NOT IN (
+			SELECT id FROM lockfile_versions WHERE repository_id = $1 ORDER BY created_at DESC LIMIT $2
+		)`,
+		repositoryId, retention,
+	)
+
+	return err
+}
+
+// ListLockfileVersionsHandler handles the GET request to list a repository's historical versions.
+func ListLockfileVersionsHandler(c *gin.Context, db *sqlx.DB) {
+	var versions []LockfileVersion
+
+	repositoryId := c.Param("repositoryId")
+	if err := db.Select(
+		&versions,
+		"SELECT id, repository_id, content_hash, created_at FROM lockfile_versions WHERE repository_id = $1 ORDER BY created_at DESC",
+		repositoryId,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": versions})
+}
+
+// GetLockfileVersionHandler handles the GET request to fetch a single historical version.
+func GetLockfileVersionHandler(c *gin.Context, db *sqlx.DB) {
+	var version LockfileVersion
+
+	repositoryId := c.Param("repositoryId")
+	versionId := c.Param("versionId")
+	err := db.Get(
+		&version,
+		"SELECT * FROM lockfile_versions WHERE repository_id = $1 AND id = $2",
+		repositoryId, versionId,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": version})
+}
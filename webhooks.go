@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// maxDeliveryAttempts is how many times the dispatcher retries a delivery
+// before giving up and leaving it in the "failed" state.
+const maxDeliveryAttempts = 5
+
+// webhookDispatchQueueSize bounds the number of deliveries buffered for the
+// dispatcher goroutine pool before enqueueing blocks.
+const webhookDispatchQueueSize = 256
+
+// webhookDispatchWorkerCount is how many goroutines concurrently drain
+// webhookDispatcher.
+const webhookDispatchWorkerCount = 4
+
+// WebhookSubscription is the database model for a webhook subscription.
+type WebhookSubscription struct {
+	ID           uuid.UUID `db:"id" json:"id"`
+	RepositoryID string    `db:"repository_id" json:"repositoryId"`
+	Url          string    `db:"url" json:"url"`
+	Secret       string    `db:"secret" json:"-"`
+	Events       string    `db:"events" json:"events"`
+	Active       bool      `db:"active" json:"active"`
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
+
+// WebhookDelivery is the database model for a single webhook delivery attempt.
+type WebhookDelivery struct {
+	ID             uuid.UUID  `db:"id" json:"id"`
+	SubscriptionID uuid.UUID  `db:"subscription_id" json:"subscriptionId"`
+	Event          string     `db:"event" json:"event"`
+	Payload        string     `db:"payload" json:"payload"`
+	Status         string     `db:"status" json:"status"`
+	Attempts       int        `db:"attempts" json:"attempts"`
+	LastError      string     `db:"last_error" json:"lastError"`
+	CreatedAt      time.Time  `db:"created_at" json:"createdAt"`
+	DeliveredAt    *time.Time `db:"delivered_at" json:"deliveredAt"`
+}
+
+// WebhooksTableSchema is the schema for the webhook_subscriptions and
+// webhook_deliveries tables.
+const WebhooksTableSchema = `
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id UUID NOT NULL PRIMARY KEY,
+    repository_id VARCHAR(255) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events VARCHAR(255) NOT NULL DEFAULT 'lockfile.updated',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID NOT NULL PRIMARY KEY,
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id),
+    event VARCHAR(255) NOT NULL,
+    payload JSON NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+`
+
+type CreateWebhookSubscriptionRequest struct {
+	Url    string `json:"url" binding:"required"`
+	Secret string `json:"secret" binding:"required"`
+	Events string `json:"events"`
+}
+
+// lockfileDiff describes what changed between two versions of a lockfile's posts.
+type lockfileDiff struct {
+	RepositoryID string            `json:"repositoryId"`
+	Added        []LockfileContent `json:"added"`
+	Removed      []LockfileContent `json:"removed"`
+	Changed      []LockfileContent `json:"changed"`
+}
+
+// webhookDispatcher fans deliveries out to a pool of worker goroutines.
+var webhookDispatcher = make(chan uuid.UUID, webhookDispatchQueueSize)
+
+// startWebhookDispatchWorkers starts numWorkers goroutines that pull queued
+// deliveries off webhookDispatcher and POST them to their subscription's URL.
+func startWebhookDispatchWorkers(db *sqlx.DB, numWorkers int) {
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			for deliveryId := range webhookDispatcher {
+				deliverWebhook(db, deliveryId)
+			}
+		}()
+	}
+}
+
+// webhookRecoveryInterval is how often recoverStuckDeliveries re-scans the
+// webhook_deliveries table for rows left in a non-terminal state.
+const webhookRecoveryInterval = time.Minute
+
+// webhookStaleDeliveryThreshold is how long a delivery's updated_at must be
+// untouched before recoverStuckDeliveries will re-enqueue it. Without this, the
+// recovery sweep would race with a worker that is still actively processing a
+// delivery (sleeping between backoff attempts), re-enqueueing it onto a second
+// worker and letting the two clobber each other's status updates.
+const webhookStaleDeliveryThreshold = 5 * time.Minute
+
+// recoverStuckDeliveries re-enqueues any delivery still in "pending",
+// "retrying" or "delivering" state whose updated_at is older than
+// webhookStaleDeliveryThreshold, then keeps doing so on
+// webhookRecoveryInterval. This covers deliveries stranded by a process
+// restart mid-backoff or mid-delivery, or between
+// recordLockfileUpdateDeliveries committing and enqueueDeliveries running.
+// "delivering" is included because a worker that crashes after claiming a
+// delivery would otherwise strand it there forever.
+func recoverStuckDeliveries(db *sqlx.DB) {
+	for {
+		var deliveryIds []uuid.UUID
+		err := db.Select(
+			&deliveryIds,
+			`SELECT id FROM webhook_deliveries
+			WHERE status IN ('pending', 'retrying', 'delivering')
+			AND updated_at < CURRENT_TIMESTAMP - ($1 * INTERVAL '1 second')`,
+			webhookStaleDeliveryThreshold.Seconds(),
+		)
+		if err != nil {
+			log.Println("failed to scan for stuck webhook deliveries", err)
+		} else if len(deliveryIds) > 0 {
+			enqueueDeliveries(deliveryIds)
+		}
+
+		time.Sleep(webhookRecoveryInterval)
+	}
+}
+
+// CreateWebhookSubscriptionHandler handles the POST request to register a new webhook subscription.
+func CreateWebhookSubscriptionHandler(c *gin.Context, db *sqlx.DB) {
+	var request CreateWebhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events := request.Events
+	if events == "" {
+		events = "lockfile.updated"
+	}
+
+	subscription := WebhookSubscription{
+		ID:           uuid.New(),
+		RepositoryID: c.Param("repositoryId"),
+		Url:          request.Url,
+		Secret:       request.Secret,
+		Events:       events,
+		Active:       true,
+	}
+
+	_, err := db.NamedExec(
+		`INSERT INTO webhook_subscriptions (id, repository_id, url, secret, events, active)
+		VALUES (:id, :repository_id, :url, :secret, :events, :active)`,
+		map[string]interface{}{
+			"id":            subscription.ID,
+			"repository_id": subscription.RepositoryID,
+			"url":           subscription.Url,
+			"secret":        subscription.Secret,
+			"events":        subscription.Events,
+			"active":        subscription.Active,
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": subscription})
+}
+
+// ListWebhookSubscriptionsHandler handles the GET request to list a repository's webhook subscriptions.
+func ListWebhookSubscriptionsHandler(c *gin.Context, db *sqlx.DB) {
+	var subscriptions []WebhookSubscription
+
+	repositoryId := c.Param("repositoryId")
+	if err := db.Select(&subscriptions, "SELECT * FROM webhook_subscriptions WHERE repository_id = $1", repositoryId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": subscriptions})
+}
+
+// DeleteWebhookSubscriptionHandler handles the DELETE request to remove a webhook subscription.
+func DeleteWebhookSubscriptionHandler(c *gin.Context, db *sqlx.DB) {
+	repositoryId := c.Param("repositoryId")
+	webhookId := c.Param("webhookId")
+
+	result, err := db.Exec(
+		"DELETE FROM webhook_subscriptions WHERE id = $1 AND repository_id = $2",
+		webhookId, repositoryId,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook subscription not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "webhook subscription deleted successfully"})
+}
+
+// GetWebhookDeliveriesHandler handles the GET request to list delivery attempts for a subscription.
+func GetWebhookDeliveriesHandler(c *gin.Context, db *sqlx.DB) {
+	var deliveries []WebhookDelivery
+
+	repositoryId := c.Param("repositoryId")
+	webhookId := c.Param("webhookId")
+	if err := db.Select(
+		&deliveries,
+		`SELECT wd.* FROM webhook_deliveries wd
+		JOIN webhook_subscriptions ws ON ws.id = wd.subscription_id
+		WHERE wd.subscription_id = $1 AND ws.repository_id = $2
+		ORDER BY wd.created_at DESC`,
+		webhookId, repositoryId,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": deliveries})
+}
+
+// diffLockfileContent compares the previous and new posts for a lockfile update,
+// returning which posts were added, removed, or changed (by id and hash).
+func diffLockfileContent(previous, next LockfileContentArray) lockfileDiff {
+	previousById := make(map[string]LockfileContent, len(previous))
+	for _, post := range previous {
+		previousById[post.ID] = post
+	}
+
+	nextById := make(map[string]LockfileContent, len(next))
+	for _, post := range next {
+		nextById[post.ID] = post
+	}
+
+	diff := lockfileDiff{}
+	for id, post := range nextById {
+		previousPost, existed := previousById[id]
+		if !existed {
+			diff.Added = append(diff.Added, post)
+			continue
+		}
+
+		if previousPost.Hash != post.Hash {
+			diff.Changed = append(diff.Changed, post)
+		}
+	}
+
+	for id, post := range previousById {
+		if _, stillExists := nextById[id]; !stillExists {
+			diff.Removed = append(diff.Removed, post)
+		}
+	}
+
+	return diff
+}
+
+// recordLockfileUpdateDeliveries inserts a pending webhook_deliveries row for
+// every active subscription on repositoryId, inside the same transaction as
+// the UPSERT that produced the diff. It returns the new delivery IDs, which
+// the caller must hand to enqueueDeliveries only after the transaction commits.
+func recordLockfileUpdateDeliveries(tx *sqlx.Tx, repositoryId string, diff lockfileDiff) ([]uuid.UUID, error) {
+	diff.RepositoryID = repositoryId
+
+	var subscriptions []WebhookSubscription
+	if err := tx.Select(
+		&subscriptions,
+		"SELECT * FROM webhook_subscriptions WHERE repository_id = $1 AND active = TRUE AND events LIKE '%lockfile.updated%'",
+		repositoryId,
+	); err != nil {
+		return nil, err
+	}
+
+	if len(subscriptions) == 0 {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryIds := make([]uuid.UUID, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		deliveryId := uuid.New()
+		_, err := tx.Exec(
+			`INSERT INTO webhook_deliveries (id, subscription_id, event, payload)
+			VALUES ($1, $2, 'lockfile.updated', $3)`,
+			deliveryId, subscription.ID, payload,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveryIds = append(deliveryIds, deliveryId)
+	}
+
+	return deliveryIds, nil
+}
+
+// enqueueDeliveries hands off a batch of previously recorded deliveries to the
+// dispatch worker pool. Call this only after the transaction that recorded
+// them has committed, so a rollback never results in a webhook we can't
+// account for.
+func enqueueDeliveries(deliveryIds []uuid.UUID) {
+	go func() {
+		for _, deliveryId := range deliveryIds {
+			webhookDispatcher <- deliveryId
+		}
+	}()
+}
+
+// claimDelivery atomically moves deliveryId from "pending" or "retrying" (or
+// from a "delivering" state untouched for webhookStaleDeliveryThreshold, left
+// behind by a crashed worker) into "delivering", reporting whether this caller
+// won the claim. This is what
+// keeps two workers processing the same delivery concurrently — whether two
+// dispatcher workers, or a dispatcher worker racing recoverStuckDeliveries —
+// from double-sending a request or clobbering each other's final status.
+func claimDelivery(db *sqlx.DB, deliveryId uuid.UUID) (bool, error) {
+	result, err := db.Exec(
+		`UPDATE webhook_deliveries SET status = 'delivering', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (status IN ('pending', 'retrying') OR (status = 'delivering'
+			AND updated_at < CURRENT_TIMESTAMP - ($2 * INTERVAL '1 second')))`,
+		deliveryId, webhookStaleDeliveryThreshold.Seconds(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// deliverWebhook sends a single queued delivery, retrying with exponential
+// backoff until it succeeds or exhausts maxDeliveryAttempts.
+func deliverWebhook(db *sqlx.DB, deliveryId uuid.UUID) {
+	claimed, err := claimDelivery(db, deliveryId)
+	if err != nil {
+		log.Println("failed to claim webhook delivery", deliveryId, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var delivery WebhookDelivery
+	if err := db.Get(&delivery, "SELECT * FROM webhook_deliveries WHERE id = $1", deliveryId); err != nil {
+		log.Println("failed to load webhook delivery", deliveryId, err)
+		return
+	}
+
+	var subscription WebhookSubscription
+	if err := db.Get(&subscription, "SELECT * FROM webhook_subscriptions WHERE id = $1", delivery.SubscriptionID); err != nil {
+		log.Println("failed to load webhook subscription", delivery.SubscriptionID, err)
+		return
+	}
+
+	for attempt := delivery.Attempts; attempt < maxDeliveryAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
+		}
+
+		err := sendWebhookRequest(subscription, delivery)
+
+		attempts := attempt + 1
+		if err == nil {
+			db.Exec(
+				"UPDATE webhook_deliveries SET status = 'delivered', attempts = $1, delivered_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+				attempts, deliveryId,
+			)
+			return
+		}
+
+		db.Exec(
+			"UPDATE webhook_deliveries SET status = 'retrying', attempts = $1, last_error = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+			attempts, err.Error(), deliveryId,
+		)
+	}
+
+	db.Exec("UPDATE webhook_deliveries SET status = 'failed', updated_at = CURRENT_TIMESTAMP WHERE id = $1", deliveryId)
+}
+
+// webhookDeliveryTimeout bounds how long sendWebhookRequest will wait on a
+// subscriber's URL. Without it, a slow or unresponsive subscriber could hang
+// a dispatcher worker indefinitely, and with only webhookDispatchWorkerCount
+// workers in the pool, a handful of stuck subscribers is enough to stall
+// every other delivery.
+const webhookDeliveryTimeout = 10 * time.Second
+
+var webhookHTTPClient = &http.Client{Timeout: webhookDeliveryTimeout}
+
+// sendWebhookRequest POSTs a delivery's payload to its subscription's URL,
+// signing the body with HMAC-SHA256 of the subscription's secret.
+func sendWebhookRequest(subscription WebhookSubscription, delivery WebhookDelivery) error {
+	mac := hmac.New(sha256.New, []byte(subscription.Secret))
+	mac.Write([]byte(delivery.Payload))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	req, err := http.NewRequest(http.MethodPost, subscription.Url, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-SHA256", signature)
+	req.Header.Set("X-Event", delivery.Event)
+	req.Header.Set("X-Delivery-ID", delivery.ID.String())
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &webhookDeliveryError{statusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+type webhookDeliveryError struct {
+	statusCode int
+}
+
+func (e *webhookDeliveryError) Error() string {
+	return http.StatusText(e.statusCode)
+}